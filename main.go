@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
 	_ "github.com/mattn/go-sqlite3"
 	"nxweb.com/octoprint-manager/utils"
 )
@@ -33,6 +37,16 @@ func main() {
 		log.Fatal("Failed to create config directory:", err)
 	}
 
+	backend := os.Getenv("BACKEND")
+	if backend == "" {
+		backend = "docker"
+	}
+	if backend != "docker" && backend != "systemd" {
+		log.Fatal("Invalid BACKEND (must be docker or systemd):", backend)
+	}
+	utils.SetBackend(backend)
+	log.Println("Using", backend, "backend for container supervision")
+
 	db, err := sql.Open("sqlite3", configdir+"/octoprint.db")
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
@@ -60,6 +74,60 @@ func main() {
 
 	log.Println("Containers table ready")
 
+	createPortAllocationsTableSQL := `CREATE TABLE IF NOT EXISTS port_allocations (
+        port INTEGER PRIMARY KEY,
+        container_id TEXT NOT NULL,
+        reserved_at DATETIME NOT NULL
+    );`
+
+	_, err = db.Exec(createPortAllocationsTableSQL)
+	if err != nil {
+		log.Fatal("Failed to create port_allocations table:", err)
+	}
+
+	log.Println("Port allocations table ready")
+
+	if err := utils.BackfillPortAllocations(db); err != nil {
+		log.Fatal("Failed to backfill port allocations:", err)
+	}
+
+	if _, err := db.Exec("ALTER TABLE containers ADD COLUMN disconnected INTEGER NOT NULL DEFAULT 0"); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatal("Failed to add disconnected column:", err)
+	}
+
+	if _, err := db.Exec("ALTER TABLE containers ADD COLUMN image_digest TEXT"); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatal("Failed to add image_digest column:", err)
+	}
+
+	if _, err := db.Exec("ALTER TABLE containers ADD COLUMN image_tag TEXT"); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatal("Failed to add image_tag column:", err)
+	}
+
+	createSettingsTableSQL := `CREATE TABLE IF NOT EXISTS settings (
+        key TEXT PRIMARY KEY,
+        value TEXT NOT NULL
+    );`
+
+	_, err = db.Exec(createSettingsTableSQL)
+	if err != nil {
+		log.Fatal("Failed to create settings table:", err)
+	}
+
+	log.Println("Settings table ready")
+
+	createDeviceOverridesTableSQL := `CREATE TABLE IF NOT EXISTS device_overrides (
+        device TEXT PRIMARY KEY,
+        port INTEGER,
+        name TEXT
+    );`
+
+	_, err = db.Exec(createDeviceOverridesTableSQL)
+	if err != nil {
+		log.Fatal("Failed to create device_overrides table:", err)
+	}
+
+	log.Println("Device overrides table ready")
+
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		log.Fatal("failed to create docker client:", err)
@@ -79,6 +147,17 @@ func main() {
 		log.Println("Some containers failed to start:", err)
 	}
 
+	eventHub := utils.NewHub()
+
+	hotplugCtx, cancelHotplug := context.WithCancel(context.Background())
+	defer cancelHotplug()
+
+	go func() {
+		if err := utils.WatchUSBHotplug(hotplugCtx, cli, db, eventHub); err != nil {
+			log.Println("USB hotplug watcher stopped:", err)
+		}
+	}()
+
 	app := fiber.New()
 	app.Static("/", "./frontend")
 
@@ -196,6 +275,11 @@ func main() {
 		}
 		containerName, err := utils.CreateNewContainer(cli, db, req.Device, configdir)
 		if err != nil {
+			if errors.Is(err, utils.ErrPortPoolExhausted) {
+				return c.Status(409).JSON(fiber.Map{
+					"error": "No ports available in the configured range",
+				})
+			}
 			return c.Status(500).JSON(fiber.Map{
 				"error": fmt.Sprintf("Failed to create container: %v", err),
 			})
@@ -260,6 +344,319 @@ func main() {
 		})
 	})
 
+	app.Use("/api/containers/:id/stats", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+
+	app.Get("/api/containers/:id/stats", websocket.New(func(conn *websocket.Conn) {
+		containerName := "octoprint-" + conn.Params("id")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		if err := utils.StreamContainerStats(ctx, cli, containerName, func(sample utils.ContainerStatsSample) {
+			if err := conn.WriteJSON(sample); err != nil {
+				cancel()
+			}
+		}); err != nil {
+			log.Println("Stats stream ended for", containerName, ":", err)
+		}
+	}))
+
+	app.Use("/api/containers/stats", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+
+	app.Get("/api/containers/stats", websocket.New(func(conn *websocket.Conn) {
+		rows, err := db.Query("SELECT id FROM containers")
+		if err != nil {
+			log.Println("Failed to query containers for fleet stats:", err)
+			return
+		}
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				log.Println("Failed to scan container id:", err)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		var writeMu sync.Mutex
+		var wg sync.WaitGroup
+		for _, id := range ids {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				containerName := "octoprint-" + id
+				err := utils.StreamContainerStats(ctx, cli, containerName, func(sample utils.ContainerStatsSample) {
+					writeMu.Lock()
+					defer writeMu.Unlock()
+					if err := conn.WriteJSON(sample); err != nil {
+						cancel()
+					}
+				})
+				if err != nil {
+					log.Println("Fleet stats stream ended for", containerName, ":", err)
+				}
+			}(id)
+		}
+		wg.Wait()
+	}))
+
+	wsLogHandler := websocket.New(func(conn *websocket.Conn) {
+		id := conn.Params("id")
+
+		sub := utils.SubscribeContainerLogs(id)
+		defer utils.UnsubscribeContainerLogs(id, sub)
+
+		for _, line := range utils.ContainerLogBacklog(id) {
+			if err := conn.WriteJSON(line); err != nil {
+				return
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case line, ok := <-sub:
+				if !ok {
+					return
+				}
+				if err := conn.WriteJSON(line); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	})
+
+	app.Get("/api/containers/:id/logs", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return wsLogHandler(c)
+		}
+
+		id := c.Params("id")
+		since := c.Query("since")
+		until := c.Query("until")
+		tailParam := c.Query("tail")
+
+		if since != "" || until != "" || tailParam != "" {
+			tail := tailParam
+			if tail == "" {
+				tail = "all"
+			}
+
+			lines, err := utils.ContainerLogsTail(c.Context(), cli, id, since, until, tail)
+			if err != nil {
+				log.Println("Failed to fetch logs:", err)
+				return c.Status(500).JSON(fiber.Map{
+					"error": "Failed to fetch logs",
+				})
+			}
+
+			return c.JSON(fiber.Map{
+				"error": false,
+				"lines": lines,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"error": false,
+			"lines": utils.ContainerLogBacklog(id),
+		})
+	})
+
+	app.Use("/api/events", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+
+	app.Get("/api/events", websocket.New(func(conn *websocket.Conn) {
+		sub := eventHub.Subscribe()
+		defer eventHub.Unsubscribe(sub)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case event, ok := <-sub:
+				if !ok {
+					return
+				}
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}))
+
+	app.Post("/api/settings/autoprovision", func(c *fiber.Ctx) error {
+
+		type Request struct {
+			Enabled bool `json:"enabled"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if err := utils.SetAutoProvision(db, req.Enabled); err != nil {
+			log.Println("Failed to update auto_provision setting:", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to update auto_provision setting",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"error":   false,
+			"enabled": req.Enabled,
+		})
+	})
+
+	app.Post("/api/devices/override", func(c *fiber.Ctx) error {
+
+		type Request struct {
+			Device string `json:"device"`
+			Port   int    `json:"port"`
+			Name   string `json:"name"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if req.Device == "" {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "device is required",
+			})
+		}
+
+		if err := utils.SetDeviceOverride(db, req.Device, req.Port, req.Name); err != nil {
+			log.Println("Failed to save device override:", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to save device override",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"error": false,
+		})
+	})
+
+	app.Post("/api/upgrade", func(c *fiber.Ctx) error {
+		go func() {
+			if err := utils.UpgradeContainers(cli, db, eventHub); err != nil {
+				log.Println("Errors while upgrading containers:", err)
+			}
+		}()
+
+		return c.JSON(fiber.Map{
+			"error": false,
+		})
+	})
+
+	app.Post("/api/containers/:id/upgrade", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		go func() {
+			if err := utils.UpgradeSingleContainer(cli, db, id, eventHub); err != nil {
+				log.Println("Failed to upgrade container", id, ":", err)
+			}
+		}()
+
+		return c.JSON(fiber.Map{
+			"error": false,
+			"id":    id,
+		})
+	})
+
+	app.Post("/api/containers/:id/tag", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		type Request struct {
+			ImageTag string `json:"image_tag"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if err := utils.SetImageTag(db, id, req.ImageTag); err != nil {
+			log.Println("Failed to set image tag for", id, ":", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to set image tag",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"error":     false,
+			"id":        id,
+			"image_tag": req.ImageTag,
+		})
+	})
+
 	app.Post("/api/deletecontainer", func(c *fiber.Ctx) error {
 
 		type Request struct {
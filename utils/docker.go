@@ -19,10 +19,14 @@ import (
 	"github.com/google/uuid"
 )
 
-func createOctoPrintContainer(cli *client.Client, id string, device string, port int) (string, error) {
+func createOctoPrintContainer(cli *client.Client, id string, device string, port int, imageRef string) (string, error) {
 	portStr := strconv.Itoa(port)
 	containerPort := "80/tcp"
 
+	if imageRef == "" {
+		imageRef = "octoprint/octoprint"
+	}
+
 	symlinkPath := "/dev/serial/by-id/" + device
 
 	usb, err := filepath.EvalSymlinks(symlinkPath)
@@ -31,7 +35,7 @@ func createOctoPrintContainer(cli *client.Client, id string, device string, port
 	}
 
 	config := &container.Config{
-		Image: "octoprint/octoprint",
+		Image: imageRef,
 		ExposedPorts: nat.PortSet{
 			nat.Port(containerPort): {},
 		},
@@ -61,9 +65,16 @@ func createOctoPrintContainer(cli *client.Client, id string, device string, port
 				},
 			},
 		},
-		RestartPolicy: container.RestartPolicy{
+	}
+
+	// With the systemd backend, the generated unit supervises the
+	// container (restart, start-on-boot, USB-unplug teardown), so
+	// Docker's own restart policy is left unset to avoid the two
+	// fighting over who restarts it.
+	if backendMode != "systemd" {
+		hostConfig.RestartPolicy = container.RestartPolicy{
 			Name: "unless-stopped",
-		},
+		}
 	}
 
 	containerName := fmt.Sprintf("octoprint-%s", id)
@@ -88,47 +99,83 @@ func createOctoPrintContainer(cli *client.Client, id string, device string, port
 	return containerName, nil
 }
 
-func getNextAvailablePort(db *sql.DB) (int, error) {
-	var maxPort sql.NullInt64
-	err := db.QueryRow("SELECT MAX(port) FROM containers").Scan(&maxPort)
-	if err != nil {
-		return 0, fmt.Errorf("failed to query max port: %w", err)
-	}
-
-	if !maxPort.Valid {
-		return 2000, nil
-	}
-
-	return int(maxPort.Int64) + 1, nil
-}
-
 func CreateNewContainer(cli *client.Client, db *sql.DB, device string) (string, error) {
 	id := uuid.New().String()
-	port, err := getNextAvailablePort(db)
+
+	override, err := GetDeviceOverride(db, device)
 	if err != nil {
-		return "", fmt.Errorf("failed to get next available port: %w", err)
+		return "", fmt.Errorf("failed to check device override: %w", err)
+	}
+
+	var port int
+	if override != nil && override.Port != 0 {
+		if err := ReservePort(db, id, override.Port); err != nil {
+			return "", err
+		}
+		port = override.Port
+	} else {
+		port, err = AllocatePort(db, id)
+		if err != nil {
+			return "", fmt.Errorf("failed to allocate port: %w", err)
+		}
 	}
 
 	volumePath := fmt.Sprintf("/mnt/storage/octoprint/%s", id)
 	if err := os.MkdirAll(volumePath, 0755); err != nil {
+		ReleasePort(db, id)
 		return "", fmt.Errorf("failed to create volume directory: %w", err)
 	}
 
-	containerName, err := createOctoPrintContainer(cli, id, device, port)
+	imageRef := defaultOctoPrintImage
+
+	containerName, err := createOctoPrintContainer(cli, id, device, port, imageRef)
 	if err != nil {
+		ReleasePort(db, id)
 		return "", err
 	}
 
-	_, err = db.Exec("INSERT INTO containers (id, device, port) VALUES (?, ?, ?)", id, device, port)
+	var name *string
+	if override != nil && override.Name != "" {
+		name = &override.Name
+	}
+
+	digest, err := inspectImageDigest(cli, imageRef)
 	if err != nil {
+		log.Println("Failed to resolve image digest for", containerName, ":", err)
+	}
+
+	_, err = db.Exec("INSERT INTO containers (id, device, port, name, image_digest) VALUES (?, ?, ?, ?, ?)", id, device, port, name, sql.NullString{String: digest, Valid: digest != ""})
+	if err != nil {
+		ReleasePort(db, id)
 		return "", fmt.Errorf("failed to insert new container into database: %w", err)
 	}
 
+	if backendMode == "systemd" {
+		if err := WriteSystemdUnit(id, device); err != nil {
+			log.Println("Failed to install systemd unit for", containerName, ":", err)
+		}
+	}
+
+	startLogFollower(cli, id)
+
 	return containerName, nil
 }
 
+// startLogFollower kicks off a best-effort background goroutine that
+// tails containerID's logs into its ring buffer until the container's
+// log stream ends (for example when the container stops), so log page
+// loads and WebSocket follows never have to wait on a fresh Docker log
+// stream.
+func startLogFollower(cli *client.Client, containerID string) {
+	go func() {
+		if err := FollowContainerLogs(context.Background(), cli, containerID); err != nil {
+			log.Println("Log follower stopped for", containerID, ":", err)
+		}
+	}()
+}
+
 func RecreateAllContainers(cli *client.Client, db *sql.DB) error {
-	rows, err := db.Query("SELECT id, device, port FROM containers")
+	rows, err := db.Query("SELECT id, device, port, image_tag FROM containers")
 	if err != nil {
 		return fmt.Errorf("failed to query containers: %w", err)
 	}
@@ -138,8 +185,9 @@ func RecreateAllContainers(cli *client.Client, db *sql.DB) error {
 	for rows.Next() {
 		var id, device string
 		var port int
+		var imageTag sql.NullString
 
-		if err := rows.Scan(&id, &device, &port); err != nil {
+		if err := rows.Scan(&id, &device, &port, &imageTag); err != nil {
 			errors = append(errors, fmt.Sprintf("failed to scan row: %v", err))
 			continue
 		}
@@ -149,21 +197,30 @@ func RecreateAllContainers(cli *client.Client, db *sql.DB) error {
 		// Check if container exists
 		containerJSON, err := cli.ContainerInspect(context.Background(), containerName)
 		if err == nil {
-			if containerJSON.State.Running {
-				continue
-			}
-			err = cli.ContainerStart(context.Background(), containerName, container.StartOptions{})
-			if err != nil {
-				errors = append(errors, fmt.Sprintf("failed to start existing container %s: %v", containerName, err))
+			if !containerJSON.State.Running {
+				err = cli.ContainerStart(context.Background(), containerName, container.StartOptions{})
+				if err != nil {
+					errors = append(errors, fmt.Sprintf("failed to start existing container %s: %v", containerName, err))
+					continue
+				}
 			}
+			startLogFollower(cli, id)
 			continue
 		}
 
-		_, err = createOctoPrintContainer(cli, id, device, port)
+		imageRef := imageTag.String
+		if imageRef == "" {
+			imageRef = defaultOctoPrintImage
+		}
+
+		_, err = createOctoPrintContainer(cli, id, device, port, imageRef)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("failed to create container %s: %v", containerName, err))
 			continue
 		}
+
+		recordImageDigest(cli, db, id, imageRef)
+		startLogFollower(cli, id)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -204,6 +261,12 @@ func EnsureOctoPrintImage(cli *client.Client) error {
 func DeleteContainer(c *fiber.Ctx, cli *client.Client, db *sql.DB, id string) error {
 	containerName := fmt.Sprintf("octoprint-%s", id)
 
+	if backendMode == "systemd" {
+		if err := RemoveSystemdUnit(id); err != nil {
+			log.Println("Failed to remove systemd unit for", containerName, ":", err)
+		}
+	}
+
 	// Stop the container
 	ctx := context.Background()
 	timeout := 10
@@ -234,6 +297,11 @@ func DeleteContainer(c *fiber.Ctx, cli *client.Client, db *sql.DB, id string) er
 		})
 	}
 
+	// Release the port allocation so it can be reused
+	if err := ReleasePort(db, id); err != nil {
+		log.Println("Failed to release port allocation:", err)
+	}
+
 	// Delete the storage directory
 	volumePath := fmt.Sprintf("/mnt/storage/octoprint/%s", id)
 	if err := os.RemoveAll(volumePath); err != nil {
@@ -0,0 +1,53 @@
+package utils
+
+import "sync"
+
+// Hub fans out events to every currently-connected subscriber, so
+// publishers (the USB hotplug watcher, the upgrade workflow, ...) don't
+// need to know how many WebSocket clients are listening on /api/events.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan any]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan any]struct{})}
+}
+
+// Subscribe registers a new listener. Callers must Unsubscribe when done.
+func (h *Hub) Subscribe() chan any {
+	ch := make(chan any, 16)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes and closes a listener previously returned by Subscribe.
+func (h *Hub) Unsubscribe(ch chan any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; !ok {
+		return
+	}
+	delete(h.subs, ch)
+	close(ch)
+}
+
+// Publish broadcasts event to every current subscriber. A subscriber whose
+// buffer is full misses this event (it stays subscribed) rather than
+// blocking the publisher.
+func (h *Hub) Publish(event any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"golang.org/x/sys/unix"
+)
+
+// HotplugEvent is a single USB or auto-provisioning notification,
+// published to the /api/events hub as devices come and go.
+type HotplugEvent struct {
+	Type        string `json:"type"` // device_added, device_removed, container_created, container_disconnected
+	Device      string `json:"device"`
+	ContainerID string `json:"containerId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// WatchUSBHotplug subscribes to the kernel uevent netlink socket and, on
+// every tty add/remove event, reconciles /dev/serial/by-id against the
+// containers table: new printers are auto-provisioned when enabled, and
+// unplugged ones are marked disconnected and stopped. It blocks until
+// ctx is cancelled.
+func WatchUSBHotplug(ctx context.Context, cli *client.Client, db *sql.DB, hub *Hub) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("failed to open uevent netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		return fmt.Errorf("failed to bind uevent netlink socket: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		unix.Close(fd)
+	}()
+
+	reconcileUSBDevices(cli, db, hub)
+
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read uevent: %w", err)
+		}
+
+		action, subsystem := parseUevent(buf[:n])
+		if subsystem != "tty" {
+			continue
+		}
+		if action != "add" && action != "remove" {
+			continue
+		}
+
+		reconcileUSBDevices(cli, db, hub)
+	}
+}
+
+// parseUevent extracts the action (e.g. "add") from a uevent's header
+// line and the SUBSYSTEM= value from its NUL-separated key=value body.
+func parseUevent(raw []byte) (action, subsystem string) {
+	fields := bytes.Split(raw, []byte{0})
+	if len(fields) > 0 {
+		if idx := bytes.IndexByte(fields[0], '@'); idx > 0 {
+			action = string(fields[0][:idx])
+		}
+	}
+
+	for _, f := range fields[1:] {
+		if rest, ok := strings.CutPrefix(string(f), "SUBSYSTEM="); ok {
+			subsystem = rest
+		}
+	}
+
+	return action, subsystem
+}
+
+// reconcileUSBDevices diffs the devices present under /dev/serial/by-id
+// against the containers table: devices that vanished get their
+// container marked disconnected and stopped, and (if auto-provisioning
+// is enabled) devices with no tracked container get one created.
+func reconcileUSBDevices(cli *client.Client, db *sql.DB, hub *Hub) {
+	present := map[string]bool{}
+	if files, err := os.ReadDir("/dev/serial/by-id"); err == nil {
+		for _, f := range files {
+			if !f.IsDir() {
+				present[f.Name()] = true
+			}
+		}
+	}
+
+	rows, err := db.Query("SELECT id, device, disconnected FROM containers")
+	if err != nil {
+		log.Println("Failed to query containers during USB reconcile:", err)
+		return
+	}
+
+	type trackedContainer struct {
+		id           string
+		device       string
+		disconnected bool
+	}
+
+	var tracked []trackedContainer
+	for rows.Next() {
+		var t trackedContainer
+		if err := rows.Scan(&t.id, &t.device, &t.disconnected); err != nil {
+			log.Println("Failed to scan container during USB reconcile:", err)
+			continue
+		}
+		tracked = append(tracked, t)
+	}
+	rows.Close()
+
+	trackedDevices := map[string]bool{}
+	for _, t := range tracked {
+		trackedDevices[t.device] = true
+
+		switch {
+		case !present[t.device] && !t.disconnected:
+			markDisconnected(cli, db, t.id, t.device, hub)
+		case present[t.device] && t.disconnected:
+			markReconnected(cli, db, t.id, t.device, hub)
+		}
+	}
+
+	autoProvision, err := AutoProvisionEnabled(db)
+	if err != nil {
+		log.Println("Failed to read auto_provision setting:", err)
+		return
+	}
+	if !autoProvision {
+		return
+	}
+
+	for device := range present {
+		if trackedDevices[device] {
+			continue
+		}
+
+		hub.Publish(HotplugEvent{Type: "device_added", Device: device})
+
+		containerName, err := CreateNewContainer(cli, db, device)
+		if err != nil {
+			log.Println("Failed to auto-provision container for", device, ":", err)
+			hub.Publish(HotplugEvent{Type: "container_created", Device: device, Error: err.Error()})
+			continue
+		}
+
+		hub.Publish(HotplugEvent{Type: "container_created", Device: device, ContainerID: containerName})
+	}
+}
+
+func markDisconnected(cli *client.Client, db *sql.DB, id, device string, hub *Hub) {
+	if _, err := db.Exec("UPDATE containers SET disconnected = 1 WHERE id = ?", id); err != nil {
+		log.Println("Failed to mark container disconnected:", err)
+		return
+	}
+
+	hub.Publish(HotplugEvent{Type: "device_removed", Device: device, ContainerID: id})
+
+	containerName := fmt.Sprintf("octoprint-%s", id)
+	if err := cli.ContainerStop(context.Background(), containerName, container.StopOptions{}); err != nil {
+		log.Println("Failed to stop disconnected container:", err)
+	}
+
+	hub.Publish(HotplugEvent{Type: "container_disconnected", Device: device, ContainerID: id})
+}
+
+func markReconnected(cli *client.Client, db *sql.DB, id, device string, hub *Hub) {
+	if _, err := db.Exec("UPDATE containers SET disconnected = 0 WHERE id = ?", id); err != nil {
+		log.Println("Failed to mark container reconnected:", err)
+		return
+	}
+
+	containerName := fmt.Sprintf("octoprint-%s", id)
+	if err := cli.ContainerStart(context.Background(), containerName, container.StartOptions{}); err != nil {
+		log.Println("Failed to restart reconnected container:", err)
+	} else {
+		// The container's prior log follower exited when
+		// markDisconnected stopped it (that ended its Follow
+		// stream), so it has to be restarted here or live log
+		// streaming stays dead for the rest of the process lifetime.
+		startLogFollower(cli, id)
+	}
+
+	hub.Publish(HotplugEvent{Type: "device_added", Device: device, ContainerID: id})
+}
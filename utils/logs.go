@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+const defaultLogRingBufferSize = 1000
+
+// LogLine is one line of container output, tagged with which stream it
+// came from, ready to forward as JSON to log consumers.
+type LogLine struct {
+	Timestamp string `json:"ts"`
+	Stream    string `json:"stream"`
+	Line      string `json:"line"`
+}
+
+// RingBuffer keeps the last `max` LogLines for a container in memory.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []LogLine
+	max   int
+}
+
+func NewRingBuffer(max int) *RingBuffer {
+	return &RingBuffer{max: max}
+}
+
+func (rb *RingBuffer) Add(line LogLine) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.lines = append(rb.lines, line)
+	if len(rb.lines) > rb.max {
+		rb.lines = rb.lines[len(rb.lines)-rb.max:]
+	}
+}
+
+func (rb *RingBuffer) Snapshot() []LogLine {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	out := make([]LogLine, len(rb.lines))
+	copy(out, rb.lines)
+	return out
+}
+
+// logStream pairs a container's ring buffer with a Hub so live followers
+// and fresh WebSocket subscribers share a single Docker log stream.
+type logStream struct {
+	ring *RingBuffer
+	hub  *Hub
+}
+
+var (
+	logStreamsMu sync.Mutex
+	logStreams   = map[string]*logStream{}
+)
+
+func getLogStream(containerID string) *logStream {
+	logStreamsMu.Lock()
+	defer logStreamsMu.Unlock()
+
+	ls, ok := logStreams[containerID]
+	if !ok {
+		ls = &logStream{ring: NewRingBuffer(logRingBufferSize()), hub: NewHub()}
+		logStreams[containerID] = ls
+	}
+	return ls
+}
+
+func logRingBufferSize() int {
+	return envIntOrDefault("OCTOPRINT_LOG_RING_SIZE", defaultLogRingBufferSize)
+}
+
+// ContainerLogBacklog returns the lines currently buffered in memory for
+// containerID, so a page load gets an instant backlog without hitting Docker.
+func ContainerLogBacklog(containerID string) []LogLine {
+	return getLogStream(containerID).ring.Snapshot()
+}
+
+// SubscribeContainerLogs registers a listener for new lines as they're
+// followed from containerID. Callers must UnsubscribeContainerLogs when done.
+func SubscribeContainerLogs(containerID string) chan any {
+	return getLogStream(containerID).hub.Subscribe()
+}
+
+func UnsubscribeContainerLogs(containerID string, ch chan any) {
+	getLogStream(containerID).hub.Unsubscribe(ch)
+}
+
+// FollowContainerLogs tails containerID's logs continuously, feeding
+// decoded lines into its ring buffer and publishing them to any
+// subscribers. It blocks until ctx is cancelled or the stream ends.
+func FollowContainerLogs(ctx context.Context, cli *client.Client, containerID string) error {
+	containerName := fmt.Sprintf("octoprint-%s", containerID)
+	ls := getLogStream(containerID)
+
+	reader, err := cli.ContainerLogs(ctx, containerName, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open log stream for %s: %w", containerName, err)
+	}
+	defer reader.Close()
+
+	return demuxDockerLogs(reader, func(stream, line string) {
+		ts, text := splitTimestamp(line)
+		entry := LogLine{Timestamp: ts, Stream: stream, Line: text}
+		ls.ring.Add(entry)
+		ls.hub.Publish(entry)
+	})
+}
+
+// ContainerLogsTail reads a historical window of containerID's logs
+// directly from Docker, honoring since/until/tail, for requests that
+// fall outside what the in-memory ring buffer covers.
+func ContainerLogsTail(ctx context.Context, cli *client.Client, containerID, since, until, tail string) ([]LogLine, error) {
+	containerName := fmt.Sprintf("octoprint-%s", containerID)
+
+	reader, err := cli.ContainerLogs(ctx, containerName, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Since:      since,
+		Until:      until,
+		Tail:       tail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs for %s: %w", containerName, err)
+	}
+	defer reader.Close()
+
+	var lines []LogLine
+	err = demuxDockerLogs(reader, func(stream, line string) {
+		ts, text := splitTimestamp(line)
+		lines = append(lines, LogLine{Timestamp: ts, Stream: stream, Line: text})
+	})
+	return lines, err
+}
+
+// demuxDockerLogs splits a Docker multiplexed log stream (an 8-byte
+// frame header -- 1 stream-type byte, 3 zero bytes, 4-byte big-endian
+// length -- followed by that many bytes of payload) into lines and
+// invokes onLine for each. Partial lines spanning frames are buffered
+// per stream until a newline arrives.
+func demuxDockerLogs(r io.Reader, onLine func(stream, line string)) error {
+	header := make([]byte, 8)
+	pending := map[byte]string{}
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+
+		streamType := header[0]
+		frameLen := binary.BigEndian.Uint32(header[4:8])
+
+		payload := make([]byte, frameLen)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		streamName := "stdout"
+		if streamType == 2 {
+			streamName = "stderr"
+		}
+
+		buf := pending[streamType] + string(payload)
+		parts := strings.Split(buf, "\n")
+		pending[streamType] = parts[len(parts)-1]
+		for _, line := range parts[:len(parts)-1] {
+			onLine(streamName, line)
+		}
+	}
+}
+
+// splitTimestamp separates the RFC3339Nano timestamp Docker prefixes to
+// each line (when Timestamps is requested) from the line's text.
+func splitTimestamp(line string) (ts, text string) {
+	idx := strings.Index(line, " ")
+	if idx == -1 {
+		return "", line
+	}
+	return line[:idx], line[idx+1:]
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultPortMin = 2000
+	defaultPortMax = 2999
+)
+
+// ErrPortPoolExhausted is returned by AllocatePort when no port in the
+// configured range is free, both in the database and on the host.
+var ErrPortPoolExhausted = errors.New("port pool exhausted")
+
+// PortRange returns the configured allocation range, reading
+// OCTOPRINT_PORT_MIN/OCTOPRINT_PORT_MAX and falling back to 2000-2999.
+func PortRange() (int, int) {
+	min := envIntOrDefault("OCTOPRINT_PORT_MIN", defaultPortMin)
+	max := envIntOrDefault("OCTOPRINT_PORT_MAX", defaultPortMax)
+	return min, max
+}
+
+func envIntOrDefault(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return fallback
+	}
+
+	return n
+}
+
+// BackfillPortAllocations seeds port_allocations with any container
+// whose port isn't already reserved there. It's safe to call on every
+// startup: existing containers (created before this table existed, or
+// otherwise missing a reservation row) get one, so the gap-scan in
+// AllocatePort never hands their port to someone else.
+func BackfillPortAllocations(db *sql.DB) error {
+	_, err := db.Exec(`
+		INSERT INTO port_allocations (port, container_id, reserved_at)
+		SELECT port, id, datetime('now') FROM containers
+		WHERE port NOT IN (SELECT port FROM port_allocations)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill port allocations: %w", err)
+	}
+	return nil
+}
+
+// AllocatePort reserves the lowest free port in the configured range for
+// containerID. A port is considered free only if it has no row in
+// port_allocations, no container already claims it directly, AND
+// nothing on the host is already bound to it, so a service started
+// outside this app can't be silently collided with.
+func AllocatePort(db *sql.DB, containerID string) (int, error) {
+	minPort, maxPort := PortRange()
+
+	rows, err := db.Query(`
+		WITH RECURSIVE seq(n) AS (
+			SELECT ?
+			UNION ALL
+			SELECT n + 1 FROM seq WHERE n < ?
+		)
+		SELECT seq.n FROM seq
+		LEFT JOIN port_allocations ON port_allocations.port = seq.n
+		LEFT JOIN containers ON containers.port = seq.n
+		WHERE port_allocations.port IS NULL AND containers.port IS NULL
+		ORDER BY seq.n
+	`, minPort, maxPort)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan for free ports: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []int
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return 0, fmt.Errorf("failed to scan candidate port: %w", err)
+		}
+		candidates = append(candidates, port)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating candidate ports: %w", err)
+	}
+
+	for _, port := range candidates {
+		if !portFreeOnHost(port) {
+			continue
+		}
+
+		_, err := db.Exec("INSERT INTO port_allocations (port, container_id, reserved_at) VALUES (?, ?, datetime('now'))", port, containerID)
+		if err != nil {
+			continue
+		}
+
+		return port, nil
+	}
+
+	return 0, ErrPortPoolExhausted
+}
+
+// ReservePort reserves a specific port for containerID, used for pinned
+// device overrides. It fails if the port is already reserved.
+func ReservePort(db *sql.DB, containerID string, port int) error {
+	_, err := db.Exec("INSERT INTO port_allocations (port, container_id, reserved_at) VALUES (?, ?, datetime('now'))", port, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to reserve pinned port %d: %w", port, err)
+	}
+	return nil
+}
+
+// ReleasePort frees containerID's reserved port, if any, so it can be
+// reused by a future allocation.
+func ReleasePort(db *sql.DB, containerID string) error {
+	_, err := db.Exec("DELETE FROM port_allocations WHERE container_id = ?", containerID)
+	if err != nil {
+		return fmt.Errorf("failed to release port allocation: %w", err)
+	}
+	return nil
+}
+
+// portFreeOnHost reports whether port can actually be bound right now,
+// catching the case where something outside this app's bookkeeping
+// already owns it.
+func portFreeOnHost(port int) bool {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DeviceOverride pins a specific by-id device to a fixed port and/or
+// name, so the same physical printer always comes back the same way
+// across auto-provisioning runs.
+type DeviceOverride struct {
+	Port int
+	Name string
+}
+
+// GetDeviceOverride looks up the override for device, returning nil if
+// none is configured.
+func GetDeviceOverride(db *sql.DB, device string) (*DeviceOverride, error) {
+	var port sql.NullInt64
+	var name sql.NullString
+
+	err := db.QueryRow("SELECT port, name FROM device_overrides WHERE device = ?", device).Scan(&port, &name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device override: %w", err)
+	}
+
+	override := &DeviceOverride{}
+	if port.Valid {
+		override.Port = int(port.Int64)
+	}
+	if name.Valid {
+		override.Name = name.String
+	}
+
+	return override, nil
+}
+
+// SetDeviceOverride creates or replaces the pinned port/name for device.
+func SetDeviceOverride(db *sql.DB, device string, port int, name string) error {
+	_, err := db.Exec(`
+		INSERT INTO device_overrides (device, port, name) VALUES (?, ?, ?)
+		ON CONFLICT(device) DO UPDATE SET port = excluded.port, name = excluded.name
+	`, device, port, name)
+	if err != nil {
+		return fmt.Errorf("failed to save device override: %w", err)
+	}
+	return nil
+}
+
+// AutoProvisionEnabled reports whether USB hotplug auto-provisioning is
+// turned on. It defaults to false until explicitly enabled.
+func AutoProvisionEnabled(db *sql.DB) (bool, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = 'auto_provision'").Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query auto_provision setting: %w", err)
+	}
+	return value == "true", nil
+}
+
+// SetAutoProvision persists the auto-provision toggle.
+func SetAutoProvision(db *sql.DB, enabled bool) error {
+	value := "false"
+	if enabled {
+		value = "true"
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('auto_provision', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, value)
+	if err != nil {
+		return fmt.Errorf("failed to update auto_provision setting: %w", err)
+	}
+	return nil
+}
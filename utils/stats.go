@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/docker/docker/client"
+)
+
+// dockerStats mirrors the subset of the Docker stats JSON stream we care
+// about. We decode into our own struct instead of the SDK's stats type so
+// the field set stays explicit and stable across client versions.
+type dockerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage  uint64   `json:"total_usage"`
+			PercpuUsage []uint64 `json:"percpu_usage"`
+		} `json:"cpu_usage"`
+		SystemCPUUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs     uint32 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	PreCPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemCPUUsage uint64 `json:"system_cpu_usage"`
+	} `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+		Limit uint64 `json:"limit"`
+		Stats struct {
+			// Cache is cgroup v1's reclaimable page-cache figure.
+			// cgroup v2 doesn't report it (it decodes as 0) and
+			// instead reports InactiveFile; cgroup v1 reports
+			// TotalInactiveFile.
+			Cache             uint64 `json:"cache"`
+			TotalInactiveFile uint64 `json:"total_inactive_file"`
+			InactiveFile      uint64 `json:"inactive_file"`
+		} `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+// ContainerStatsSample is a single decoded, percentage-computed stats
+// snapshot for one container, suitable for marshalling straight to JSON.
+type ContainerStatsSample struct {
+	ContainerID string  `json:"containerId"`
+	CPUPercent  float64 `json:"cpuPercent"`
+	MemPercent  float64 `json:"memPercent"`
+	MemUsage    uint64  `json:"memUsage"`
+	MemLimit    uint64  `json:"memLimit"`
+	RxBytes     uint64  `json:"rxBytes"`
+	TxBytes     uint64  `json:"txBytes"`
+}
+
+func cpuPercent(s dockerStats) float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemCPUUsage) - float64(s.PreCPUStats.SystemCPUUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	onlineCPUs := float64(s.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		// Older API versions (and some cgroup v1 setups) omit
+		// online_cpus entirely, which decodes as 0. docker stats
+		// falls back to the length of the percpu_usage list in that
+		// case, and only defaults to a single core if that's empty
+		// too.
+		if n := len(s.CPUStats.CPUUsage.PercpuUsage); n > 0 {
+			onlineCPUs = float64(n)
+		} else {
+			onlineCPUs = 1
+		}
+	}
+
+	return (cpuDelta / systemDelta) * onlineCPUs * 100
+}
+
+// memWorkingSetUsage subtracts reclaimable page cache from raw memory
+// usage, the same way `docker stats` does: prefer cgroup v1's
+// total_inactive_file, fall back to cgroup v2's inactive_file, then the
+// older cache field, and never subtract a figure that isn't smaller
+// than usage (a stale/zero reading on the wrong cgroup version must not
+// underflow the uint64 subtraction into a huge bogus value).
+func memWorkingSetUsage(s dockerStats) uint64 {
+	usage := s.MemoryStats.Usage
+	stats := s.MemoryStats.Stats
+
+	switch {
+	case stats.TotalInactiveFile > 0 && stats.TotalInactiveFile < usage:
+		return usage - stats.TotalInactiveFile
+	case stats.InactiveFile > 0 && stats.InactiveFile < usage:
+		return usage - stats.InactiveFile
+	case stats.Cache > 0 && stats.Cache < usage:
+		return usage - stats.Cache
+	default:
+		return usage
+	}
+}
+
+func memPercent(s dockerStats) float64 {
+	if s.MemoryStats.Limit == 0 {
+		return 0
+	}
+	return (float64(memWorkingSetUsage(s)) / float64(s.MemoryStats.Limit)) * 100
+}
+
+// StreamContainerStats decodes cli.ContainerStats' JSON stream for
+// containerName and invokes onSample for every sample, until ctx is
+// cancelled or the stream ends. Docker emits a sample roughly once a
+// second, so callers get an effective ~1Hz feed for free.
+func StreamContainerStats(ctx context.Context, cli *client.Client, containerName string, onSample func(ContainerStatsSample)) error {
+	resp, err := cli.ContainerStats(ctx, containerName, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var s dockerStats
+		if err := decoder.Decode(&s); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		sample := ContainerStatsSample{
+			ContainerID: containerName,
+			CPUPercent:  cpuPercent(s),
+			MemPercent:  memPercent(s),
+			MemUsage:    memWorkingSetUsage(s),
+			MemLimit:    s.MemoryStats.Limit,
+		}
+		for _, n := range s.Networks {
+			sample.RxBytes += n.RxBytes
+			sample.TxBytes += n.TxBytes
+		}
+
+		onSample(sample)
+	}
+}
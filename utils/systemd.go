@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+)
+
+const systemdUnitTemplate = `[Unit]
+Description=OctoPrint instance %s
+After=docker.service
+BindsTo=%s
+
+[Service]
+ExecStart=/usr/bin/docker start -a %s
+ExecStop=/usr/bin/docker stop -t 10 %s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// backendMode selects how container lifecycle is supervised: "docker"
+// (the default, Docker's own restart policy) or "systemd" (a generated
+// unit per container, set via SetBackend at startup).
+var backendMode = "docker"
+
+// SetBackend chooses the supervision backend for containers created or
+// deleted from this point on.
+func SetBackend(mode string) {
+	backendMode = mode
+}
+
+// Backend reports the currently configured supervision backend.
+func Backend() string {
+	return backendMode
+}
+
+// escapeDeviceUnit mangles a /dev/serial/by-id name the way
+// `systemd-escape -p --suffix=device` would, so a unit's BindsTo= can
+// reference the backing device unit and stop automatically when the
+// USB device is unplugged.
+func escapeDeviceUnit(device string) string {
+	return fmt.Sprintf("dev-serial-by\\x2did-%s.device", escapeSystemdPathComponent(device))
+}
+
+// escapeSystemdPathComponent escapes a single path segment the way
+// systemd-escape does: every byte outside [A-Za-z0-9_.] (and a leading
+// ".") is replaced with its \xHH hex escape. Plain string replacement
+// of just "-" misses other punctuation (":" in particular, common in
+// by-id names), which silently breaks the unit name it's used to build.
+func escapeSystemdPathComponent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		safe := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '.'
+		if i == 0 && c == '.' {
+			safe = false
+		}
+		if safe {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
+
+func systemdUnitName(id string) string {
+	return fmt.Sprintf("octoprint-%s.service", id)
+}
+
+// systemdUnitDir returns where unit files are written: the system
+// directory when running as root, or the user's rootless unit
+// directory otherwise.
+func systemdUnitDir() (dir string, userScope bool, err error) {
+	if os.Geteuid() == 0 {
+		return "/etc/systemd/system", false, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	return filepath.Join(home, ".config/systemd/user"), true, nil
+}
+
+func connectSystemd(userScope bool) (*systemdDbus.Conn, error) {
+	if userScope {
+		conn, err := systemdDbus.NewUserConnectionContext(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to user systemd bus: %w", err)
+		}
+		return conn, nil
+	}
+
+	conn, err := systemdDbus.NewSystemConnectionContext(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system systemd bus: %w", err)
+	}
+	return conn, nil
+}
+
+// WriteSystemdUnit renders and installs the systemd unit for container
+// id, bound to device's by-id device unit, then reloads and enables+
+// starts it via dbus.
+func WriteSystemdUnit(id, device string) error {
+	dir, userScope, err := systemdUnitDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create systemd unit directory: %w", err)
+	}
+
+	containerName := fmt.Sprintf("octoprint-%s", id)
+	unit := fmt.Sprintf(systemdUnitTemplate, containerName, escapeDeviceUnit(device), containerName, containerName)
+
+	path := filepath.Join(dir, systemdUnitName(id))
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write systemd unit %s: %w", path, err)
+	}
+
+	conn, err := connectSystemd(userScope)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{systemdUnitName(id)}, userScope, true); err != nil {
+		return fmt.Errorf("failed to enable systemd unit: %w", err)
+	}
+
+	if _, err := conn.StartUnitContext(ctx, systemdUnitName(id), "replace", nil); err != nil {
+		return fmt.Errorf("failed to start systemd unit: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveSystemdUnit stops, disables and deletes container id's systemd unit.
+func RemoveSystemdUnit(id string) error {
+	dir, userScope, err := systemdUnitDir()
+	if err != nil {
+		return err
+	}
+
+	conn, err := connectSystemd(userScope)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if _, err := conn.StopUnitContext(ctx, systemdUnitName(id), "replace", nil); err != nil {
+		log.Println("Failed to stop systemd unit (may not exist):", err)
+	}
+
+	if _, err := conn.DisableUnitFilesContext(ctx, []string{systemdUnitName(id)}, userScope); err != nil {
+		log.Println("Failed to disable systemd unit:", err)
+	}
+
+	if err := conn.ReloadContext(ctx); err != nil {
+		log.Println("Failed to reload systemd after disable:", err)
+	}
+
+	path := filepath.Join(dir, systemdUnitName(id))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove systemd unit file %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+const defaultOctoPrintImage = "octoprint/octoprint:latest"
+
+// UpgradeEvent reports upgrade progress for a single container,
+// published to the /api/events hub so the UI can render a live list.
+type UpgradeEvent struct {
+	Type        string `json:"type"` // upgrade_progress
+	ContainerID string `json:"containerId"`
+	Phase       string `json:"phase"` // pulling, up_to_date, stopping, recreating, started, failed
+	Error       string `json:"error,omitempty"`
+}
+
+// UpgradeContainers pulls the latest image for each container (or its
+// pinned image_tag), and recreates every container whose recorded
+// image_digest is stale, preserving its bind-mounted storage volume.
+// Progress for each container is published to hub.
+func UpgradeContainers(cli *client.Client, db *sql.DB, hub *Hub) error {
+	rows, err := db.Query("SELECT id, device, port, image_digest, image_tag FROM containers")
+	if err != nil {
+		return fmt.Errorf("failed to query containers: %w", err)
+	}
+
+	type containerRow struct {
+		id, device string
+		port       int
+		digest     sql.NullString
+		imageTag   sql.NullString
+	}
+
+	var all []containerRow
+	for rows.Next() {
+		var r containerRow
+		if err := rows.Scan(&r.id, &r.device, &r.port, &r.digest, &r.imageTag); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan container row: %w", err)
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating container rows: %w", err)
+	}
+
+	digestCache := map[string]string{}
+	var errs []string
+
+	for _, r := range all {
+		tag := r.imageTag.String
+		if tag == "" {
+			tag = defaultOctoPrintImage
+		}
+
+		digest, ok := digestCache[tag]
+		if !ok {
+			hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: r.id, Phase: "pulling"})
+
+			var err error
+			digest, err = pullImageDigest(cli, tag)
+			if err != nil {
+				hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: r.id, Phase: "failed", Error: err.Error()})
+				errs = append(errs, fmt.Sprintf("%s: %v", r.id, err))
+				continue
+			}
+			digestCache[tag] = digest
+		}
+
+		if r.digest.Valid && r.digest.String == digest {
+			hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: r.id, Phase: "up_to_date"})
+			continue
+		}
+
+		if err := recreateWithImage(cli, db, r.id, r.device, r.port, tag, digest, hub); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", r.id, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("encountered errors while upgrading containers: %v", errs)
+	}
+	return nil
+}
+
+// UpgradeSingleContainer upgrades one container by id, following the
+// same pull/stop/recreate sequence as UpgradeContainers.
+func UpgradeSingleContainer(cli *client.Client, db *sql.DB, id string, hub *Hub) error {
+	var device string
+	var port int
+	var imageTag sql.NullString
+
+	err := db.QueryRow("SELECT device, port, image_tag FROM containers WHERE id = ?", id).Scan(&device, &port, &imageTag)
+	if err != nil {
+		return fmt.Errorf("failed to look up container %s: %w", id, err)
+	}
+
+	tag := imageTag.String
+	if tag == "" {
+		tag = defaultOctoPrintImage
+	}
+
+	hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "pulling"})
+	digest, err := pullImageDigest(cli, tag)
+	if err != nil {
+		hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "failed", Error: err.Error()})
+		return err
+	}
+
+	return recreateWithImage(cli, db, id, device, port, tag, digest, hub)
+}
+
+// SetImageTag pins id's image_tag to tag, so the next upgrade recreates
+// it from that tag instead of defaultOctoPrintImage. Passing an empty
+// tag clears the pin.
+func SetImageTag(db *sql.DB, id, tag string) error {
+	_, err := db.Exec("UPDATE containers SET image_tag = ? WHERE id = ?", sql.NullString{String: tag, Valid: tag != ""}, id)
+	if err != nil {
+		return fmt.Errorf("failed to set image tag for %s: %w", id, err)
+	}
+	return nil
+}
+
+// pullImageDigest pulls tag unconditionally and returns its resolved
+// image digest, so callers can tell whether a running container is
+// already on the latest content regardless of tag mutability.
+func pullImageDigest(cli *client.Client, tag string) (string, error) {
+	reader, err := cli.ImagePull(context.Background(), tag, image.PullOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %w", tag, err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return "", fmt.Errorf("failed to read pull output for %s: %w", tag, err)
+	}
+
+	return inspectImageDigest(cli, tag)
+}
+
+// inspectImageDigest resolves tag's currently-cached local digest
+// without pulling, used to record what image a container was actually
+// created from.
+func inspectImageDigest(cli *client.Client, tag string) (string, error) {
+	inspect, err := cli.ImageInspect(context.Background(), tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", tag, err)
+	}
+
+	if len(inspect.RepoDigests) == 0 {
+		return inspect.ID, nil
+	}
+	return inspect.RepoDigests[0], nil
+}
+
+// recordImageDigest resolves imageRef's locally-cached digest and saves
+// it as id's image_digest, so a later upgrade can tell it's already
+// current instead of recreating every container unconditionally.
+func recordImageDigest(cli *client.Client, db *sql.DB, id, imageRef string) {
+	digest, err := inspectImageDigest(cli, imageRef)
+	if err != nil {
+		log.Println("Failed to resolve image digest for", id, ":", err)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE containers SET image_digest = ? WHERE id = ?", digest, id); err != nil {
+		log.Println("Failed to record image digest for", id, ":", err)
+	}
+}
+
+// recreateWithImage stops and removes id's container -- the bind-mounted
+// storage volume under /mnt/storage/octoprint/<id> is untouched by
+// container removal -- recreates it from the already-pulled image, and
+// records the new digest.
+func recreateWithImage(cli *client.Client, db *sql.DB, id, device string, port int, imageRef, digest string, hub *Hub) error {
+	containerName := fmt.Sprintf("octoprint-%s", id)
+	ctx := context.Background()
+
+	hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "stopping"})
+
+	timeout := 10
+	if err := cli.ContainerStop(ctx, containerName, container.StopOptions{Timeout: &timeout}); err != nil {
+		log.Println("Failed to stop container before upgrade (may not exist):", err)
+	}
+
+	if err := cli.ContainerRemove(ctx, containerName, container.RemoveOptions{Force: true}); err != nil {
+		hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "failed", Error: err.Error()})
+		return fmt.Errorf("failed to remove container %s: %w", containerName, err)
+	}
+
+	hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "recreating"})
+
+	if _, err := createOctoPrintContainer(cli, id, device, port, imageRef); err != nil {
+		hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "failed", Error: err.Error()})
+		return fmt.Errorf("failed to recreate container %s: %w", containerName, err)
+	}
+
+	if _, err := db.Exec("UPDATE containers SET image_digest = ? WHERE id = ?", digest, id); err != nil {
+		return fmt.Errorf("failed to record image digest for %s: %w", id, err)
+	}
+
+	startLogFollower(cli, id)
+	hub.Publish(UpgradeEvent{Type: "upgrade_progress", ContainerID: id, Phase: "started"})
+	return nil
+}